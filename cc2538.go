@@ -1,11 +1,17 @@
 package cc2538
 
 import (
+	"bytes"
+	"context"
 	"io"
 	"fmt"
 	"errors"
+	"hash/crc32"
 	"log"
+	"sort"
 	"time"
+
+	"github.com/malvira/go-cc2538/firmware"
 )
 
 const (
@@ -85,12 +91,16 @@ func ScanPort (port io.Reader, out chan Frame, kill chan bool) {
 		select {
 		case <- kill:
 			debug.Println("port scanner killed")
-			break
+			return
 		default:
 		}
-		
+
 		resp := make([]byte, 1)
-		n, _ := port.Read(resp)
+		n, err := port.Read(resp)
+		if err != nil {
+			debug.Println("port scanner read error:", err)
+			return
+		}
 		if n == 0 { continue }
 
 		if first {
@@ -139,63 +149,125 @@ func (f Frame) chipID() int {
 	return r
 }
 
-func needACK(frames chan Frame) error {
+// recvFrame waits for the next frame from frames, bounded by ACKWAIT and ctx.
+func recvFrame(ctx context.Context, frames chan Frame) (Frame, error) {
 	select {
-	case f := <- frames:
-		if f.isAck() {
-			debug.Println("got ACK [0xcc]")
-			return nil
-		} else if f.isNak() {
-			debug.Println("got NAK [0x33]")
-			return errors.New("got NAK needed ACK")
-		} else {
-			return errors.New(fmt.Sprintf("unexpected data in needACK: %#v", f))
-		}
-	case <- time.After(ACKWAIT * time.Second):
-		return errors.New("timed out waiting for ACK")
+	case f := <-frames:
+		return f, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(ACKWAIT * time.Second):
+		return nil, errors.New("timed out waiting for frame")
+	}
+}
+
+func needACK(ctx context.Context, frames chan Frame) error {
+	f, err := recvFrame(ctx, frames)
+	if err != nil {
+		return err
+	}
+	if f.isAck() {
+		debug.Println("got ACK [0xcc]")
+		return nil
+	} else if f.isNak() {
+		debug.Println("got NAK [0x33]")
+		return errors.New("got NAK needed ACK")
+	} else {
+		return errors.New(fmt.Sprintf("unexpected data in needACK: %#v", f))
+	}
+}
+
+// RetryPolicy bounds how many times a Bootloader re-attempts a failed
+// operation, and with what backoff. The zero value makes a single
+// attempt and does not retry.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	OnRetry     func(attempt int, err error)
+}
+
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
 	}
+	return p.MaxAttempts
 }
 
 type Bootloader struct {
 	Port io.ReadWriteCloser // serial port
 	Frames chan Frame       // channel to recieve frames from
+	Retry RetryPolicy       // governs retries of Sync, Flash and Program
 }
 
-func (c Bootloader) Sync() error {
-	b := []byte{0x55, 0x55}
-	n, err := c.Port.Write(b)
-	if err != nil {
-		log.Fatalf("port.Write: %v", err)
-		return err
+// withRetry runs op up to c.Retry's MaxAttempts times, sleeping Backoff
+// between attempts and reporting each failed attempt that will be
+// retried to OnRetry; the final failure is returned, not reported.
+func (c Bootloader) withRetry(ctx context.Context, op func() error) error {
+	attempts := c.Retry.maxAttempts()
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = op(); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		if c.Retry.OnRetry != nil {
+			c.Retry.OnRetry(attempt, err)
+		}
+		if c.Retry.Backoff > 0 {
+			select {
+			case <-time.After(c.Retry.Backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
 	}
-	_ = n
-	err = needACK(c.Frames)
 	return err
 }
 
-func (c Bootloader) Ping() bool {
+// Sync sends the 0x55 0x55 autobaud sequence and waits for an ACK,
+// retrying per c.Retry.
+func (c Bootloader) Sync(ctx context.Context) error {
+	return c.withRetry(ctx, func() error {
+		if _, err := c.Port.Write([]byte{0x55, 0x55}); err != nil {
+			return err
+		}
+		return needACK(ctx, c.Frames)
+	})
+}
+
+func (c Bootloader) Ping(ctx context.Context) error {
 	com := Packet{COMMAND_PING, nil}
-	c.Port.Write(com.Serialize())
-	needACK(c.Frames)
-	return true
+	if _, err := c.Port.Write(com.Serialize()); err != nil {
+		return err
+	}
+	return needACK(ctx, c.Frames)
 }
 
 
-func (c Bootloader) GetChipID() int {
+func (c Bootloader) GetChipID(ctx context.Context) (int, error) {
 	com := Packet{COMMAND_GET_CHIP_ID, nil}
-	c.Port.Write(com.Serialize())
+	if _, err := c.Port.Write(com.Serialize()); err != nil {
+		return 0, err
+	}
 
-	needACK(c.Frames)
+	if err := needACK(ctx, c.Frames); err != nil {
+		return 0, err
+	}
 
 	// get the ID
 	// cc2538: 0x00 00  b9  64
 	//            0  0 185 100
-	frame := <- c.Frames
+	frame, err := recvFrame(ctx, c.Frames)
+	if err != nil {
+		return 0, err
+	}
 	id := frame.chipID()
 	// need to send an ACK. Why! (grrr..ti)
 	c.ack()
 
-	return id
+	return id, nil
 }
 
 
@@ -210,91 +282,318 @@ func (c Bootloader) ack() {
 	c.Port.Write([]byte{0xcc})
 }
 
-func (c Bootloader) Erase(addr uint32, num uint32) {
+func (c Bootloader) Erase(ctx context.Context, addr uint32, num uint32) error {
 	p := make([]byte, 8)
 	pack32(addr, p[0:4])
 	pack32(num, p[4:8])
 	com := Packet{COMMAND_ERASE, p}
-	c.Port.Write(com.Serialize())
-	needACK(c.Frames)
+	if _, err := c.Port.Write(com.Serialize()); err != nil {
+		return err
+	}
+	return needACK(ctx, c.Frames)
 }
 
-func (c Bootloader) Crc32(addr uint32, num uint32) uint32 {
+func (c Bootloader) Crc32(ctx context.Context, addr uint32, num uint32) (uint32, error) {
 	p := make([]byte, 8)
 	pack32(addr, p[0:4])
 	pack32(num, p[4:8])
 	com := Packet{COMMAND_CRC32, p}
-	c.Port.Write(com.Serialize())
-	needACK(c.Frames)
-	crc := <- c.Frames
+	if _, err := c.Port.Write(com.Serialize()); err != nil {
+		return 0, err
+	}
+	if err := needACK(ctx, c.Frames); err != nil {
+		return 0, err
+	}
+	crc, err := recvFrame(ctx, c.Frames)
+	if err != nil {
+		return 0, err
+	}
 	crc = crc[2:] // first two bytes are len and checksum
 	c.ack()
-	return uint32(crc[3]) << 24 | uint32(crc[2]) << 16 | uint32(crc[1]) << 8 | uint32(crc[0])
+	return uint32(crc[3]) << 24 | uint32(crc[2]) << 16 | uint32(crc[1]) << 8 | uint32(crc[0]), nil
 }
 
 // width can be 1 for byte read or 4 for 32bit word read
 // regardless of width, uint32 with the data is always returned.
-func (c Bootloader) Read(addr uint32, width uint) uint32 {
+func (c Bootloader) Read(ctx context.Context, addr uint32, width uint) (uint32, error) {
 	p := make([]byte, 5)
 	pack32(addr, p[0:4])
 	p[4] = byte(width)
 	com := Packet{COMMAND_MEMORY_READ, p}
-	c.Port.Write(com.Serialize())
-	needACK(c.Frames)
-	data := <- c.Frames
+	if _, err := c.Port.Write(com.Serialize()); err != nil {
+		return 0, err
+	}
+	if err := needACK(ctx, c.Frames); err != nil {
+		return 0, err
+	}
+	data, err := recvFrame(ctx, c.Frames)
+	if err != nil {
+		return 0, err
+	}
 	data = data[2:] // first two bytes are len and checksum
 	c.ack()
-	return uint32(data[3]) << 24 | uint32(data[2]) << 16 | uint32(data[1]) << 8 | uint32(data[0])
+	return uint32(data[3]) << 24 | uint32(data[2]) << 16 | uint32(data[1]) << 8 | uint32(data[0]), nil
 }
 
-func (c Bootloader) Download(addr uint32, len uint32) {
+func (c Bootloader) Download(ctx context.Context, addr uint32, len uint32) error {
 	p := make([]byte, 8)
 	pack32(addr, p[0:4])
 	pack32(len, p[4:8])
 	com := Packet{COMMAND_DOWNLOAD, p}
-	c.Port.Write(com.Serialize())
-	needACK(c.Frames)
+	if _, err := c.Port.Write(com.Serialize()); err != nil {
+		return err
+	}
+	return needACK(ctx, c.Frames)
 }
 
 // send up to 252 bytes in a COMMAND_SEND_DATA
-func (c Bootloader) SendData(data []byte) {
+func (c Bootloader) SendData(ctx context.Context, data []byte) error {
 	com := Packet{COMMAND_SEND_DATA, data}
-	c.Port.Write(com.Serialize())
-	needACK(c.Frames)
+	if _, err := c.Port.Write(com.Serialize()); err != nil {
+		return err
+	}
+	return needACK(ctx, c.Frames)
 }
 
-func (c Bootloader) GetStatus() commandCode {
+func (c Bootloader) GetStatus(ctx context.Context) (commandCode, error) {
 	com := Packet{COMMAND_GET_STATUS, nil}
-	c.Port.Write(com.Serialize())
-	needACK(c.Frames)
-	data := <- c.Frames
-	fmt.Println("status frame:", data)
+	if _, err := c.Port.Write(com.Serialize()); err != nil {
+		return 0, err
+	}
+	if err := needACK(ctx, c.Frames); err != nil {
+		return 0, err
+	}
+	data, err := recvFrame(ctx, c.Frames)
+	if err != nil {
+		return 0, err
+	}
+	debug.Println("status frame:", data)
 	data = data[2:] // first two bytes are len and checksum
 	c.ack()
-	return commandCode(data[0])
-}
-
-// flash does a "download" followed by "send_data" to write a payload
-func (c Bootloader) Flash(addr uint32, payload []byte) {
-	len := len(payload)
-	c.Download(addr, uint32(len))
-	i := 0
-	for i < len {
-		amt := len - i
-		if amt >= 248 {
-			amt = 248
+	return commandCode(data[0]), nil
+}
+
+// ProgramOptions controls how Program writes a firmware.Image to the
+// device.
+type ProgramOptions struct {
+	// WriteCCA permits Program to touch the CCA page (FLASH_CCA_PAGE).
+	// By default a segment that overlaps it is rejected.
+	WriteCCA bool
+}
+
+// align rounds the byte range [addr, addr+length) out to a whole
+// number of flash pages, returning the page-aligned start address and
+// length to pass to Erase.
+func align(addr, length uint32) (pageAddr, pageLen uint32) {
+	end := addr + length
+	pageAddr = (addr / FLASH_PAGE_SIZE) * FLASH_PAGE_SIZE
+	pageEnd := ((end + FLASH_PAGE_SIZE - 1) / FLASH_PAGE_SIZE) * FLASH_PAGE_SIZE
+	return pageAddr, pageEnd - pageAddr
+}
+
+// overlapsPage reports whether the page-aligned range [pageAddr,
+// pageAddr+pageLen) includes the given flash page number.
+func overlapsPage(pageAddr, pageLen, page uint32) bool {
+	start := FLASH_BASE_ADDR + page*FLASH_PAGE_SIZE
+	end := start + FLASH_PAGE_SIZE
+	return pageAddr < end && pageAddr+pageLen > start
+}
+
+// pagesOf returns the distinct, page-aligned flash page addresses
+// covered by segs, in ascending order.
+func pagesOf(segs []firmware.Segment) []uint32 {
+	seen := make(map[uint32]struct{})
+	for _, seg := range segs {
+		pageAddr, pageLen := align(seg.Addr, uint32(len(seg.Data)))
+		for p := pageAddr; p < pageAddr+pageLen; p += FLASH_PAGE_SIZE {
+			seen[p] = struct{}{}
 		}
-		c.SendData(payload[i:i+amt])
-		status := c.GetStatus()
-		switch status {
-		case COMMAND_RET_FLASH_FAIL:
-			fmt.Printf("flashing failed with status 0x%x: addr %x start %x len %x; will retry\n", status, addr, i, amt)
-		case COMMAND_RET_SUCCESS:
-			i += amt
-		default:
-			fmt.Printf("flashing failed with status 0x%x: addr %x start %x len %x; stopping\n", status, addr, i, amt)
-			goto out
+	}
+	pages := make([]uint32, 0, len(seen))
+	for p := range seen {
+		pages = append(pages, p)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+	return pages
+}
+
+// Program writes a parsed firmware.Image to the device: every flash
+// page the image touches is erased exactly once (retried per c.Retry),
+// and only then are the segments written with Flash.
+func (c Bootloader) Program(ctx context.Context, fw firmware.Image, opts ProgramOptions) error {
+	for _, pageAddr := range pagesOf(fw.Segments) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !opts.WriteCCA && overlapsPage(pageAddr, FLASH_PAGE_SIZE, FLASH_CCA_PAGE) {
+			return fmt.Errorf("cc2538: image touches the CCA page at 0x%08x; set ProgramOptions.WriteCCA to allow this", pageAddr)
+		}
+
+		if err := c.withRetry(ctx, func() error {
+			if err := c.Erase(ctx, pageAddr, FLASH_PAGE_SIZE); err != nil {
+				return err
+			}
+			status, err := c.GetStatus(ctx)
+			if err != nil {
+				return err
+			}
+			if status != COMMAND_RET_SUCCESS {
+				return fmt.Errorf("cc2538: erase at 0x%08x failed with status 0x%x", pageAddr, status)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, seg := range fw.Segments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.Flash(ctx, seg.Addr, seg.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyError reports a CRC32 mismatch between a device's flash and
+// the payload it was supposed to have been written from.
+type VerifyError struct {
+	Addr uint32
+	Want uint32
+	Got  uint32
+}
+
+func (e VerifyError) Error() string {
+	return fmt.Sprintf("cc2538: crc32 mismatch at 0x%08x: want 0x%08x got 0x%08x", e.Addr, e.Want, e.Got)
+}
+
+// Verify computes the CRC32 of payload locally and compares it against
+// the device's CRC32 over the same address range, returning a
+// VerifyError on mismatch.
+func (c Bootloader) Verify(ctx context.Context, addr uint32, payload []byte) error {
+	want := crc32.ChecksumIEEE(payload)
+	got, err := c.Crc32(ctx, addr, uint32(len(payload)))
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return VerifyError{Addr: addr, Want: want, Got: got}
+	}
+	return nil
+}
+
+// ReadBlock reads n bytes starting at addr, paging through memory in
+// 4-byte COMMAND_MEMORY_READ reads.
+func (c Bootloader) ReadBlock(ctx context.Context, addr uint32, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		word, err := c.Read(ctx, addr+uint32(len(out)), 4)
+		if err != nil {
+			return nil, err
+		}
+		chunk := []byte{byte(word), byte(word >> 8), byte(word >> 16), byte(word >> 24)}
+		if remain := n - len(out); remain < 4 {
+			chunk = chunk[:remain]
 		}
+		out = append(out, chunk...)
 	}
-out:
+	return out, nil
+}
+
+// maxChunkSize is the largest payload Bootloader will put in a single
+// COMMAND_SEND_DATA packet.
+const maxChunkSize = 248
+
+// FlashRequest describes a streaming flash write: Total bytes read
+// from Source are written starting at Addr, in ChunkSize pieces (or
+// maxChunkSize if ChunkSize is zero or exceeds it). Progress, if set,
+// is called after each chunk is acknowledged by the device.
+type FlashRequest struct {
+	Addr      uint32
+	Source    io.Reader
+	Total     uint32
+	ChunkSize int
+	Progress  func(written, total uint32)
+}
+
+// FlashStream does a "download" followed by a series of "send_data"
+// writes streamed from req.Source, retrying each chunk per c.Retry,
+// and verifies the write against a CRC32 computed locally as bytes
+// are read.
+func (c Bootloader) FlashStream(ctx context.Context, req FlashRequest) error {
+	chunkSize := req.ChunkSize
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		chunkSize = maxChunkSize
+	}
+
+	if err := c.Download(ctx, req.Addr, req.Total); err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize)
+	sum := crc32.NewIEEE()
+	var written uint32
+
+	for written < req.Total {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		want := uint32(chunkSize)
+		if remain := req.Total - written; want > remain {
+			want = remain
+		}
+
+		n, err := io.ReadFull(req.Source, buf[:want])
+		if err != nil {
+			return fmt.Errorf("cc2538: reading firmware source: %w", err)
+		}
+		chunk := buf[:n]
+		sum.Write(chunk)
+
+		if err := c.withRetry(ctx, func() error {
+			if err := c.SendData(ctx, chunk); err != nil {
+				return err
+			}
+			status, err := c.GetStatus(ctx)
+			if err != nil {
+				return err
+			}
+			if status != COMMAND_RET_SUCCESS {
+				return fmt.Errorf("cc2538: flashing failed with status 0x%x: addr %x start %x len %x", status, req.Addr, written, n)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		written += uint32(n)
+		if req.Progress != nil {
+			req.Progress(written, req.Total)
+		}
+	}
+
+	want := sum.Sum32()
+	got, err := c.Crc32(ctx, req.Addr, req.Total)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return VerifyError{Addr: req.Addr, Want: want, Got: got}
+	}
+	return nil
+}
+
+// Flash writes payload at addr. It's a thin wrapper around
+// FlashStream; call FlashStream directly for progress reporting, a
+// configurable chunk size, or streaming from disk.
+func (c Bootloader) Flash(ctx context.Context, addr uint32, payload []byte) error {
+	return c.FlashStream(ctx, FlashRequest{
+		Addr:   addr,
+		Source: bytes.NewReader(payload),
+		Total:  uint32(len(payload)),
+	})
 }
@@ -0,0 +1,196 @@
+package cc2538_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/malvira/go-cc2538"
+	"github.com/malvira/go-cc2538/cc2538test"
+	"github.com/malvira/go-cc2538/firmware"
+)
+
+func TestMain(m *testing.M) {
+	cc2538.SetDebugLogger(log.New(io.Discard, "", 0))
+	os.Exit(m.Run())
+}
+
+// runScanPort starts ScanPort over port and registers a cleanup that
+// closes closer and blocks until ScanPort has actually returned -
+// ScanPort exits as soon as a Read on a closed port errors, so this
+// never leaves a spinning goroutine behind after the test ends.
+func runScanPort(t *testing.T, port io.Reader, closer io.Closer, frames chan cc2538.Frame) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		cc2538.ScanPort(port, frames, make(chan bool))
+		close(done)
+	}()
+	t.Cleanup(func() {
+		closer.Close()
+		<-done
+	})
+}
+
+// newTestPair wires a Bootloader up to a fake cc2538test.Device over an
+// in-memory duplex pipe, mirroring how a real caller pairs
+// Bootloader.Port with a goroutine draining ScanPort into
+// Bootloader.Frames.
+func newTestPair(t *testing.T) (cc2538.Bootloader, *cc2538test.Device) {
+	t.Helper()
+	deviceEnd, hostEnd := cc2538test.NewPTYPair(t)
+	dev := cc2538test.NewDevice(deviceEnd)
+	go dev.Serve()
+	t.Cleanup(func() { deviceEnd.Close() })
+
+	frames := make(chan cc2538.Frame, 16)
+	runScanPort(t, hostEnd, hostEnd, frames)
+
+	return cc2538.Bootloader{Port: hostEnd, Frames: frames}, dev
+}
+
+func TestSyncPingGetChipID(t *testing.T) {
+	bl, _ := newTestPair(t)
+	ctx := context.Background()
+
+	if err := bl.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := bl.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	id, err := bl.GetChipID(ctx)
+	if err != nil {
+		t.Fatalf("GetChipID: %v", err)
+	}
+	if id != 0xb964 {
+		t.Errorf("GetChipID = 0x%x, want 0xb964", id)
+	}
+}
+
+// TestGetChipIDRespectsContextWhenDataFrameNeverArrives is a regression
+// test: a device that ACKs a command but never sends the data frame
+// GetChipID expects afterwards used to hang the caller forever, since
+// the channel receive for that frame wasn't ctx-aware.
+func TestGetChipIDRespectsContextWhenDataFrameNeverArrives(t *testing.T) {
+	deviceEnd, hostEnd := cc2538test.NewPTYPair(t)
+	t.Cleanup(func() { hostEnd.Close(); deviceEnd.Close() })
+
+	// ACKs every command, never follows up with a data frame.
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := deviceEnd.Read(buf)
+			if err != nil {
+				return
+			}
+			if n > 0 {
+				deviceEnd.Write([]byte{0x00, 0xcc})
+			}
+		}
+	}()
+
+	frames := make(chan cc2538.Frame, 16)
+	runScanPort(t, hostEnd, hostEnd, frames)
+	bl := cc2538.Bootloader{Port: hostEnd, Frames: frames}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := bl.GetChipID(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetChipID error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > cc2538.ACKWAIT*time.Second {
+		t.Errorf("GetChipID took %v, should have returned on ctx deadline well before the %ds ACKWAIT fallback", elapsed, cc2538.ACKWAIT)
+	}
+}
+
+// TestProgramErasesSharedPageOnlyOnce is a regression test for a bug
+// where two segments landing in the same flash page caused Program to
+// erase that page once per segment, wiping out the first segment's
+// data when the second was flashed.
+func TestProgramErasesSharedPageOnlyOnce(t *testing.T) {
+	bl, _ := newTestPair(t)
+	ctx := context.Background()
+
+	if err := bl.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	first := bytes.Repeat([]byte{0xAA}, 16)
+	second := bytes.Repeat([]byte{0xBB}, 16)
+	fw := firmware.Image{Segments: []firmware.Segment{
+		{Addr: cc2538.FLASH_BASE_ADDR, Data: first},
+		{Addr: cc2538.FLASH_BASE_ADDR + 1024, Data: second},
+	}}
+
+	if err := bl.Program(ctx, fw, cc2538.ProgramOptions{}); err != nil {
+		t.Fatalf("Program: %v", err)
+	}
+
+	got, err := bl.ReadBlock(ctx, cc2538.FLASH_BASE_ADDR, len(first))
+	if err != nil {
+		t.Fatalf("ReadBlock first segment: %v", err)
+	}
+	if !bytes.Equal(got, first) {
+		t.Errorf("first segment = % x, want % x (flashing the second segment must not re-erase this page)", got, first)
+	}
+
+	got, err = bl.ReadBlock(ctx, cc2538.FLASH_BASE_ADDR+1024, len(second))
+	if err != nil {
+		t.Fatalf("ReadBlock second segment: %v", err)
+	}
+	if !bytes.Equal(got, second) {
+		t.Errorf("second segment = % x, want % x", got, second)
+	}
+}
+
+func TestFlashAndVerify(t *testing.T) {
+	bl, _ := newTestPair(t)
+	ctx := context.Background()
+
+	if err := bl.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := bl.Erase(ctx, cc2538.FLASH_BASE_ADDR, cc2538.FLASH_PAGE_SIZE); err != nil {
+		t.Fatalf("Erase: %v", err)
+	}
+
+	payload := bytes.Repeat([]byte{0x42}, 300) // bigger than one SEND_DATA chunk
+	if err := bl.Flash(ctx, cc2538.FLASH_BASE_ADDR, payload); err != nil {
+		t.Fatalf("Flash: %v", err)
+	}
+	if err := bl.Verify(ctx, cc2538.FLASH_BASE_ADDR, payload); err != nil {
+		t.Errorf("Verify against the written payload: %v", err)
+	}
+	if err := bl.Verify(ctx, cc2538.FLASH_BASE_ADDR, bytes.Repeat([]byte{0x43}, 300)); err == nil {
+		t.Error("Verify against a different payload: want error, got nil")
+	}
+}
+
+func TestSetBootloaderBackdoorRoundTrip(t *testing.T) {
+	bl, _ := newTestPair(t)
+	ctx := context.Background()
+
+	if err := bl.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := bl.SetBootloaderBackdoor(ctx, true, false, 5); err != nil {
+		t.Fatalf("SetBootloaderBackdoor: %v", err)
+	}
+
+	cca, err := bl.ReadCCA(ctx)
+	if err != nil {
+		t.Fatalf("ReadCCA: %v", err)
+	}
+	want := cc2538.BackdoorConfig{Enabled: true, ActiveLow: false, Pin: 5}
+	if cca.Backdoor != want {
+		t.Errorf("Backdoor = %+v, want %+v", cca.Backdoor, want)
+	}
+}
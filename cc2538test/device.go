@@ -0,0 +1,239 @@
+// Package cc2538test implements an in-process fake CC2538 bootloader
+// so that the real serial-port Bootloader code paths (framing in
+// ScanPort, ACK/NAK handling, ACK timeouts) can be exercised in
+// `go test` without hardware attached.
+package cc2538test
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/malvira/go-cc2538"
+)
+
+const flashSize = 512 * 1024
+
+// Device is a fake CC2538 speaking the bootloader wire protocol over
+// an io.ReadWriter, backed by an in-memory flash array.
+type Device struct {
+	rw    io.ReadWriter
+	flash [flashSize]byte
+	chipID uint16
+
+	downloadAddr uint32
+	downloadLen  uint32
+	downloadOff  uint32
+	lastStatus   byte
+}
+
+// NewDevice returns a fake device that will serve bootloader commands
+// read from rw. The default chip ID matches a real cc2538: 0xb964.
+func NewDevice(rw io.ReadWriter) *Device {
+	return &Device{rw: rw, chipID: 0xb964}
+}
+
+// Serve processes commands from rw until a read or write on rw fails
+// (typically io.EOF when the test closes its end of the connection),
+// a packet is too short, or a command addresses memory outside the
+// fake flash, and returns that error.
+func (d *Device) Serve() error {
+	for {
+		if err := d.handleOne(); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Device) handleOne() error {
+	var b [1]byte
+	if _, err := io.ReadFull(d.rw, b[:]); err != nil {
+		return err
+	}
+
+	// the 0x55 0x55 autobaud sequence sent by Sync isn't a framed
+	// packet - just two raw bytes answered with a plain ACK.
+	if b[0] == 0x55 {
+		var b2 [1]byte
+		if _, err := io.ReadFull(d.rw, b2[:]); err != nil {
+			return err
+		}
+		return d.sendAck()
+	}
+
+	total := int(b[0])
+	if total < 3 {
+		return fmt.Errorf("cc2538test: short packet length %d", total)
+	}
+	rest := make([]byte, total-1)
+	if _, err := io.ReadFull(d.rw, rest); err != nil {
+		return err
+	}
+
+	chksum, cmd, payload := rest[0], rest[1], rest[2:]
+	sum := int(cmd)
+	for _, v := range payload {
+		sum += int(v)
+	}
+	if chksum != byte(sum%256) {
+		return d.sendNak()
+	}
+
+	return d.handleCommand(cmd, payload)
+}
+
+func (d *Device) handleCommand(cmd byte, payload []byte) error {
+	switch cmd {
+	case byte(cc2538.COMMAND_PING):
+		return d.sendAck()
+
+	case byte(cc2538.COMMAND_GET_CHIP_ID):
+		if err := d.sendAck(); err != nil {
+			return err
+		}
+		return d.sendData([]byte{0x00, 0x00, byte(d.chipID >> 8), byte(d.chipID)})
+
+	case byte(cc2538.COMMAND_ERASE):
+		if len(payload) != 8 {
+			return d.sendNak()
+		}
+		addr, size := be32(payload[0:4]), be32(payload[4:8])
+		if err := d.erase(addr, size); err != nil {
+			return err
+		}
+		d.lastStatus = byte(cc2538.COMMAND_RET_SUCCESS)
+		return d.sendAck()
+
+	case byte(cc2538.COMMAND_DOWNLOAD):
+		if len(payload) != 8 {
+			return d.sendNak()
+		}
+		d.downloadAddr = be32(payload[0:4])
+		d.downloadLen = be32(payload[4:8])
+		d.downloadOff = 0
+		return d.sendAck()
+
+	case byte(cc2538.COMMAND_SEND_DATA):
+		if err := d.write(d.downloadAddr+d.downloadOff, payload); err != nil {
+			d.lastStatus = byte(cc2538.COMMAND_RET_FLASH_FAIL)
+			return d.sendAck()
+		}
+		d.downloadOff += uint32(len(payload))
+		d.lastStatus = byte(cc2538.COMMAND_RET_SUCCESS)
+		return d.sendAck()
+
+	case byte(cc2538.COMMAND_GET_STATUS):
+		if err := d.sendAck(); err != nil {
+			return err
+		}
+		return d.sendData([]byte{d.lastStatus})
+
+	case byte(cc2538.COMMAND_CRC32):
+		if len(payload) != 8 {
+			return d.sendNak()
+		}
+		region, err := d.read(be32(payload[0:4]), be32(payload[4:8]))
+		if err != nil {
+			return err
+		}
+		crc := crc32.ChecksumIEEE(region)
+		if err := d.sendAck(); err != nil {
+			return err
+		}
+		return d.sendData([]byte{byte(crc), byte(crc >> 8), byte(crc >> 16), byte(crc >> 24)})
+
+	case byte(cc2538.COMMAND_MEMORY_READ):
+		if len(payload) != 5 {
+			return d.sendNak()
+		}
+		width := payload[4]
+		region, err := d.read(be32(payload[0:4]), uint32(width))
+		if err != nil {
+			return err
+		}
+		var word uint32
+		for i, b := range region {
+			word |= uint32(b) << uint(8*i)
+		}
+		if err := d.sendAck(); err != nil {
+			return err
+		}
+		return d.sendData([]byte{byte(word), byte(word >> 8), byte(word >> 16), byte(word >> 24)})
+
+	default:
+		return d.sendNak()
+	}
+}
+
+func (d *Device) erase(addr, size uint32) error {
+	region, err := d.flashRange(addr, size)
+	if err != nil {
+		return err
+	}
+	for i := range region {
+		region[i] = 0xff
+	}
+	return nil
+}
+
+func (d *Device) write(addr uint32, data []byte) error {
+	region, err := d.flashRange(addr, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+	copy(region, data)
+	return nil
+}
+
+func (d *Device) read(addr, size uint32) ([]byte, error) {
+	region, err := d.flashRange(addr, size)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(region))
+	copy(out, region)
+	return out, nil
+}
+
+func (d *Device) flashRange(addr, size uint32) ([]byte, error) {
+	if addr < cc2538.FLASH_BASE_ADDR {
+		return nil, errors.New("cc2538test: address below FLASH_BASE_ADDR")
+	}
+	off := addr - cc2538.FLASH_BASE_ADDR
+	if uint64(off)+uint64(size) > uint64(len(d.flash)) {
+		return nil, fmt.Errorf("cc2538test: address 0x%08x+%d out of range", addr, size)
+	}
+	return d.flash[off : off+size], nil
+}
+
+func (d *Device) sendAck() error {
+	_, err := d.rw.Write([]byte{0x00, 0xcc})
+	return err
+}
+
+func (d *Device) sendNak() error {
+	_, err := d.rw.Write([]byte{0x00, 0x33})
+	return err
+}
+
+// sendData writes a data-response frame ([len, checksum, payload...])
+// and then reads and discards the single raw ACK byte the host sends
+// back to acknowledge receipt - mirroring Bootloader.ack().
+func (d *Device) sendData(payload []byte) error {
+	sum := 0
+	for _, b := range payload {
+		sum += int(b)
+	}
+	frame := append([]byte{byte(2 + len(payload)), byte(sum % 256)}, payload...)
+	if _, err := d.rw.Write(frame); err != nil {
+		return err
+	}
+	var ack [1]byte
+	_, err := io.ReadFull(d.rw, ack[:])
+	return err
+}
+
+func be32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
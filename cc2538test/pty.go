@@ -0,0 +1,38 @@
+package cc2538test
+
+import (
+	"io"
+	"testing"
+)
+
+// rwc glues a pair of unidirectional pipe ends into a single
+// io.ReadWriteCloser.
+type rwc struct {
+	io.Reader
+	io.Writer
+	closers []io.Closer
+}
+
+func (r rwc) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// NewPTYPair returns a connected, in-memory duplex pair suitable for
+// driving a Bootloader's real serial-port code paths end-to-end
+// without hardware, built from a pair of crossed io.Pipes.
+func NewPTYPair(t *testing.T) (deviceEnd, hostEnd io.ReadWriteCloser) {
+	t.Helper()
+
+	toHost, toHostW := io.Pipe()
+	toDevice, toDeviceW := io.Pipe()
+
+	deviceEnd = rwc{Reader: toDevice, Writer: toHostW, closers: []io.Closer{toDevice, toHostW}}
+	hostEnd = rwc{Reader: toHost, Writer: toDeviceW, closers: []io.Closer{toHost, toDeviceW}}
+	return deviceEnd, hostEnd
+}
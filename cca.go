@@ -0,0 +1,174 @@
+package cc2538
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// numLockWords is the number of 32-bit flash-page lock words stored
+// at the tail of the CCA page: one bit per flash page, 32 pages per
+// word.
+const numLockWords = int(NUM_FLASH_PAGES / 32)
+
+const (
+	backdoorDisabledWord uint32 = 0xFFFFFFFF
+	imageValidWord       uint32 = 0x00000000
+	imageInvalidWord     uint32 = 0xFFFFFFFF
+)
+
+// maxBackdoorPin is the highest valid Pin encoding: 4 ports (A-D) of 8
+// pins each, encoded as port*8+pin.
+const maxBackdoorPin = 31
+
+// BackdoorConfig models the bootloader backdoor configuration word
+// stored in the first 4 bytes of the CCA. When Enabled, holding Pin at
+// the configured level across reset forces the ROM bootloader to stay
+// resident instead of jumping to the application image.
+type BackdoorConfig struct {
+	Enabled   bool
+	ActiveLow bool  // trigger level: true = active low, false = active high
+	Pin       uint8 // GPIO pin, encoded as (port*8 + pin), 0-31
+}
+
+// word encodes b into its on-device 32-bit representation. An enabled
+// config with an out-of-range Pin is rejected rather than silently
+// masked into one that collides with backdoorDisabledWord.
+func (b BackdoorConfig) word() (uint32, error) {
+	if !b.Enabled {
+		return backdoorDisabledWord, nil
+	}
+	if b.Pin > maxBackdoorPin {
+		return 0, fmt.Errorf("cc2538: backdoor pin %d out of range (want 0-%d, encoded as port*8+pin)", b.Pin, maxBackdoorPin)
+	}
+	w := uint32(0xFFFFFF00) | 0x01 // reserved bits set, enable bit set
+	if !b.ActiveLow {
+		w |= 0x02
+	}
+	w |= uint32(b.Pin) << 2
+	if w == backdoorDisabledWord {
+		return 0, errors.New("cc2538: encoded backdoor word collides with the disabled sentinel")
+	}
+	return w, nil
+}
+
+func backdoorFromWord(w uint32) BackdoorConfig {
+	if w == backdoorDisabledWord {
+		return BackdoorConfig{}
+	}
+	return BackdoorConfig{
+		Enabled:   w&0x01 != 0,
+		ActiveLow: w&0x02 == 0,
+		Pin:       uint8((w >> 2) & 0x3f),
+	}
+}
+
+// CCA models the fields of the CC2538 Customer Configuration Area that
+// matter to a flashing tool. Everything else in the FLASH_PAGE_SIZE-byte
+// CCA page is reserved and left untouched by WriteCCA.
+type CCA struct {
+	Backdoor        BackdoorConfig
+	ImageValid      bool
+	ImageVectorAddr uint32
+	LockBits        [numLockWords]uint32
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func le32Bytes(n uint32) []byte {
+	return []byte{byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)}
+}
+
+// ReadCCA reads the CCA page and decodes it into a CCA value.
+func (c Bootloader) ReadCCA(ctx context.Context) (CCA, error) {
+	header, err := c.ReadBlock(ctx, CCA_BASE_ADDR, 12)
+	if err != nil {
+		return CCA{}, err
+	}
+
+	lockAddr := CCA_BASE_ADDR + FLASH_PAGE_SIZE - uint32(numLockWords*4)
+	lockRaw, err := c.ReadBlock(ctx, lockAddr, numLockWords*4)
+	if err != nil {
+		return CCA{}, err
+	}
+
+	cca := CCA{
+		Backdoor:        backdoorFromWord(le32(header[0:4])),
+		ImageValid:      le32(header[4:8]) == imageValidWord,
+		ImageVectorAddr: le32(header[8:12]),
+	}
+	for i := range cca.LockBits {
+		cca.LockBits[i] = le32(lockRaw[i*4 : i*4+4])
+	}
+	return cca, nil
+}
+
+// WriteCCA performs a read-modify-write of the CCA page: the current
+// page contents are read back, the fields of cca are patched in, and
+// the page is erased and rewritten with FlashStream.
+func (c Bootloader) WriteCCA(ctx context.Context, cca CCA) error {
+	page, err := c.ReadBlock(ctx, CCA_BASE_ADDR, int(FLASH_PAGE_SIZE))
+	if err != nil {
+		return err
+	}
+
+	backdoorWord, err := cca.Backdoor.word()
+	if err != nil {
+		return err
+	}
+	copy(page[0:4], le32Bytes(backdoorWord))
+	valid := imageInvalidWord
+	if cca.ImageValid {
+		valid = imageValidWord
+	}
+	copy(page[4:8], le32Bytes(valid))
+	copy(page[8:12], le32Bytes(cca.ImageVectorAddr))
+
+	lockOff := len(page) - numLockWords*4
+	for i, word := range cca.LockBits {
+		copy(page[lockOff+i*4:lockOff+i*4+4], le32Bytes(word))
+	}
+
+	if err := c.Erase(ctx, CCA_BASE_ADDR, FLASH_PAGE_SIZE); err != nil {
+		return err
+	}
+	status, err := c.GetStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if status != COMMAND_RET_SUCCESS {
+		return fmt.Errorf("cc2538: erasing CCA page failed with status 0x%x", status)
+	}
+
+	return c.FlashStream(ctx, FlashRequest{
+		Addr:   CCA_BASE_ADDR,
+		Source: bytes.NewReader(page),
+		Total:  uint32(len(page)),
+	})
+}
+
+// ReadIEEEAddr reads the factory-programmed IEEE 802.15.4 MAC address
+// out of the info page at IEEE_ADDR.
+func (c Bootloader) ReadIEEEAddr(ctx context.Context) ([8]byte, error) {
+	var addr [8]byte
+	raw, err := c.ReadBlock(ctx, IEEE_ADDR, 8)
+	if err != nil {
+		return addr, err
+	}
+	copy(addr[:], raw)
+	return addr, nil
+}
+
+// SetBootloaderBackdoor enables or disables the bootloader backdoor
+// pin check, leaving the rest of the CCA untouched.
+func (c Bootloader) SetBootloaderBackdoor(ctx context.Context, enabled bool, activeLow bool, pin uint8) error {
+	cca, err := c.ReadCCA(ctx)
+	if err != nil {
+		return err
+	}
+	cca.Backdoor = BackdoorConfig{Enabled: enabled, ActiveLow: activeLow, Pin: pin}
+	return c.WriteCCA(ctx, cca)
+}
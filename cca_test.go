@@ -0,0 +1,33 @@
+package cc2538
+
+import "testing"
+
+func TestBackdoorConfigWordRoundTrip(t *testing.T) {
+	cases := []BackdoorConfig{
+		{},
+		{Enabled: true, ActiveLow: false, Pin: 0},
+		{Enabled: true, ActiveLow: true, Pin: 31},
+		{Enabled: true, ActiveLow: false, Pin: 17},
+	}
+	for _, want := range cases {
+		w, err := want.word()
+		if err != nil {
+			t.Fatalf("word(%+v): %v", want, err)
+		}
+		if got := backdoorFromWord(w); got != want {
+			t.Errorf("round trip %+v -> 0x%08x -> %+v", want, w, got)
+		}
+	}
+}
+
+// TestBackdoorConfigWordRejectsOutOfRangePin is a regression test: an
+// enabled config with ActiveLow false and Pin 0x3f used to encode to
+// exactly 0xFFFFFFFF, the same sentinel backdoorFromWord treats as
+// "disabled" - so a written-enabled backdoor read back as disabled.
+func TestBackdoorConfigWordRejectsOutOfRangePin(t *testing.T) {
+	b := BackdoorConfig{Enabled: true, ActiveLow: false, Pin: 0x3f}
+	w, err := b.word()
+	if err == nil {
+		t.Fatalf("word(%+v) = 0x%08x, want error for out-of-range Pin", b, w)
+	}
+}
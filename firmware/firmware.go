@@ -0,0 +1,71 @@
+// Package firmware parses firmware images in the formats emitted by
+// TI's CCS and IAR toolchains (Intel HEX and Motorola S-Record) into a
+// set of flat (address, bytes) segments that can be handed to a
+// cc2538 Bootloader for programming.
+package firmware
+
+import "sort"
+
+// Segment is a contiguous run of firmware bytes destined for a single
+// starting address in target flash.
+type Segment struct {
+	Addr uint32
+	Data []byte
+}
+
+// End returns the address one past the last byte in the segment.
+func (s Segment) End() uint32 {
+	return s.Addr + uint32(len(s.Data))
+}
+
+// Image is an ordered, non-overlapping set of Segments parsed from a
+// firmware file.
+type Image struct {
+	Segments []Segment
+}
+
+// Bounds returns the lowest address and the address one past the
+// highest byte covered by the image. It returns (0, 0) for an empty
+// image.
+func (img Image) Bounds() (low, high uint32) {
+	if len(img.Segments) == 0 {
+		return 0, 0
+	}
+	low = img.Segments[0].Addr
+	high = img.Segments[0].End()
+	for _, seg := range img.Segments[1:] {
+		if seg.Addr < low {
+			low = seg.Addr
+		}
+		if seg.End() > high {
+			high = seg.End()
+		}
+	}
+	return low, high
+}
+
+// sortAndCoalesce orders segments by address and merges any that are
+// adjacent or overlapping, since HEX/SREC files are typically emitted
+// as many small sequential records and flashing them individually
+// would be far slower than necessary.
+func sortAndCoalesce(segs []Segment) []Segment {
+	if len(segs) == 0 {
+		return segs
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Addr < segs[j].Addr })
+
+	out := make([]Segment, 0, len(segs))
+	cur := segs[0]
+	for _, seg := range segs[1:] {
+		if seg.Addr <= cur.End() {
+			if over := int64(cur.End()) - int64(seg.Addr); over < int64(len(seg.Data)) {
+				cur.Data = append(cur.Data, seg.Data[over:]...)
+			}
+			continue
+		}
+		out = append(out, cur)
+		cur = seg
+	}
+	out = append(out, cur)
+	return out
+}
@@ -0,0 +1,94 @@
+package firmware
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Intel HEX record types.
+const (
+	hexRecData             = 0x00
+	hexRecEOF              = 0x01
+	hexRecExtSegmentAddr   = 0x02
+	hexRecStartSegmentAddr = 0x03
+	hexRecExtLinearAddr    = 0x04
+	hexRecStartLinearAddr  = 0x05
+)
+
+// ParseHex parses an Intel HEX file (as emitted by TI's CCS/IAR
+// toolchains) into an Image. Extended segment (02) and extended linear
+// (04) address records are honored; start address records are parsed
+// but otherwise ignored, since the bootloader has no use for them.
+func ParseHex(r io.Reader) (Image, error) {
+	scanner := bufio.NewScanner(r)
+
+	var segs []Segment
+	var extAddr uint32 // high bits contributed by record type 02 or 04
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		if text[0] != ':' {
+			return Image{}, fmt.Errorf("firmware: hex line %d: missing ':' start code", line)
+		}
+
+		raw, err := hex.DecodeString(text[1:])
+		if err != nil {
+			return Image{}, fmt.Errorf("firmware: hex line %d: %w", line, err)
+		}
+		if len(raw) < 5 {
+			return Image{}, fmt.Errorf("firmware: hex line %d: record too short", line)
+		}
+
+		byteCount := int(raw[0])
+		addr := uint32(raw[1])<<8 | uint32(raw[2])
+		recType := raw[3]
+		if len(raw) != 5+byteCount {
+			return Image{}, fmt.Errorf("firmware: hex line %d: byte count mismatch", line)
+		}
+		data := raw[4 : 4+byteCount]
+
+		sum := byte(0)
+		for _, b := range raw[:len(raw)-1] {
+			sum += b
+		}
+		if chk := byte(0x100 - int(sum)); chk != raw[len(raw)-1] {
+			return Image{}, fmt.Errorf("firmware: hex line %d: checksum mismatch", line)
+		}
+
+		switch recType {
+		case hexRecData:
+			full := extAddr + addr
+			cp := make([]byte, len(data))
+			copy(cp, data)
+			segs = append(segs, Segment{Addr: full, Data: cp})
+		case hexRecEOF:
+			// nothing more to do
+		case hexRecExtSegmentAddr:
+			if len(data) != 2 {
+				return Image{}, fmt.Errorf("firmware: hex line %d: malformed extended segment address record", line)
+			}
+			extAddr = (uint32(data[0])<<8 | uint32(data[1])) << 4
+		case hexRecExtLinearAddr:
+			if len(data) != 2 {
+				return Image{}, fmt.Errorf("firmware: hex line %d: malformed extended linear address record", line)
+			}
+			extAddr = (uint32(data[0])<<8 | uint32(data[1])) << 16
+		case hexRecStartSegmentAddr, hexRecStartLinearAddr:
+			// start addresses aren't meaningful for a bootloader target
+		default:
+			return Image{}, fmt.Errorf("firmware: hex line %d: unsupported record type 0x%02x", line, recType)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Image{}, fmt.Errorf("firmware: reading hex: %w", err)
+	}
+
+	return Image{Segments: sortAndCoalesce(segs)}, nil
+}
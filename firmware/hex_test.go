@@ -0,0 +1,68 @@
+package firmware
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHexCoalescesAdjacentRecords(t *testing.T) {
+	src := strings.Join([]string{
+		":0400000001020304F2",
+		":0400040005060708DE",
+		":00000001FF",
+	}, "\n")
+
+	img, err := ParseHex(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("ParseHex: %v", err)
+	}
+	if len(img.Segments) != 1 {
+		t.Fatalf("Segments = %d, want 1 (adjacent records should coalesce)", len(img.Segments))
+	}
+	seg := img.Segments[0]
+	if seg.Addr != 0 {
+		t.Errorf("Addr = 0x%x, want 0", seg.Addr)
+	}
+	want := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	if string(seg.Data) != string(want) {
+		t.Errorf("Data = % x, want % x", seg.Data, want)
+	}
+}
+
+func TestParseHexExtendedLinearAddress(t *testing.T) {
+	src := strings.Join([]string{
+		":020000040010EA",
+		":02000000AABB99",
+		":00000001FF",
+	}, "\n")
+
+	img, err := ParseHex(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("ParseHex: %v", err)
+	}
+	if len(img.Segments) != 1 {
+		t.Fatalf("Segments = %d, want 1", len(img.Segments))
+	}
+	seg := img.Segments[0]
+	if want := uint32(0x00100000); seg.Addr != want {
+		t.Errorf("Addr = 0x%08x, want 0x%08x", seg.Addr, want)
+	}
+	if want := []byte{0xAA, 0xBB}; string(seg.Data) != string(want) {
+		t.Errorf("Data = % x, want % x", seg.Data, want)
+	}
+}
+
+func TestParseHexBadChecksum(t *testing.T) {
+	// last byte changed from F2 to F3, so the checksum no longer matches.
+	src := ":0400000001020304F3"
+
+	if _, err := ParseHex(strings.NewReader(src)); err == nil {
+		t.Fatal("ParseHex: want error for bad checksum, got nil")
+	}
+}
+
+func TestParseHexMissingStartCode(t *testing.T) {
+	if _, err := ParseHex(strings.NewReader("0400000001020304F2")); err == nil {
+		t.Fatal("ParseHex: want error for missing ':' start code, got nil")
+	}
+}
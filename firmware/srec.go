@@ -0,0 +1,102 @@
+package firmware
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// ParseSREC parses a Motorola S-Record file (.srec/.s19, as emitted by
+// TI's CCS/IAR toolchains) into an Image. S1/S2/S3 data records are
+// collected; S0 header and S5/S7/S8/S9 count/termination records are
+// parsed for validation but contribute no data.
+func ParseSREC(r io.Reader) (Image, error) {
+	scanner := bufio.NewScanner(r)
+
+	var segs []Segment
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		if text[0] != 'S' {
+			return Image{}, fmt.Errorf("firmware: srec line %d: missing 'S' start code", line)
+		}
+		if len(text) < 4 {
+			return Image{}, fmt.Errorf("firmware: srec line %d: record too short", line)
+		}
+
+		recType := text[1]
+		raw, err := hex.DecodeString(text[2:])
+		if err != nil {
+			return Image{}, fmt.Errorf("firmware: srec line %d: %w", line, err)
+		}
+		if len(raw) < 1 {
+			return Image{}, fmt.Errorf("firmware: srec line %d: record too short", line)
+		}
+
+		byteCount := int(raw[0])
+		if len(raw) != 1+byteCount {
+			return Image{}, fmt.Errorf("firmware: srec line %d: byte count mismatch", line)
+		}
+		payload := raw[1 : 1+byteCount]
+
+		sum := byte(0)
+		for _, b := range raw[:len(raw)-1] {
+			sum += b
+		}
+		if chk := ^sum; chk != raw[len(raw)-1] {
+			return Image{}, fmt.Errorf("firmware: srec line %d: checksum mismatch", line)
+		}
+
+		addrLen, isData := 0, false
+		switch recType {
+		case '0':
+			addrLen = 2
+		case '1':
+			addrLen, isData = 2, true
+		case '2':
+			addrLen, isData = 3, true
+		case '3':
+			addrLen, isData = 4, true
+		case '5', '6':
+			addrLen = 2
+		case '7':
+			addrLen = 4
+		case '8':
+			addrLen = 3
+		case '9':
+			addrLen = 2
+		default:
+			return Image{}, fmt.Errorf("firmware: srec line %d: unsupported record type S%c", line, recType)
+		}
+		if len(payload) < addrLen {
+			return Image{}, fmt.Errorf("firmware: srec line %d: address truncated", line)
+		}
+
+		if !isData {
+			continue
+		}
+		if len(payload) < addrLen+1 {
+			return Image{}, fmt.Errorf("firmware: srec line %d: record too short for address and checksum", line)
+		}
+
+		var addr uint32
+		for _, b := range payload[:addrLen] {
+			addr = addr<<8 | uint32(b)
+		}
+		data := payload[addrLen : len(payload)-1]
+		cp := make([]byte, len(data))
+		copy(cp, data)
+		segs = append(segs, Segment{Addr: addr, Data: cp})
+	}
+	if err := scanner.Err(); err != nil {
+		return Image{}, fmt.Errorf("firmware: reading srec: %w", err)
+	}
+
+	return Image{Segments: sortAndCoalesce(segs)}, nil
+}
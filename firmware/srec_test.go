@@ -0,0 +1,68 @@
+package firmware
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSRECCoalescesAdjacentRecords(t *testing.T) {
+	src := strings.Join([]string{
+		"S00600004844521B",
+		"S107000001020304EE",
+		"S107000405060708DA",
+		"S9030000FC",
+	}, "\n")
+
+	img, err := ParseSREC(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("ParseSREC: %v", err)
+	}
+	if len(img.Segments) != 1 {
+		t.Fatalf("Segments = %d, want 1 (adjacent S1 records should coalesce)", len(img.Segments))
+	}
+	seg := img.Segments[0]
+	if seg.Addr != 0 {
+		t.Errorf("Addr = 0x%x, want 0", seg.Addr)
+	}
+	want := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	if string(seg.Data) != string(want) {
+		t.Errorf("Data = % x, want % x", seg.Data, want)
+	}
+}
+
+func TestParseSRECS2ThreeByteAddress(t *testing.T) {
+	src := strings.Join([]string{
+		"S206010000AABB93",
+		"S9030000FC",
+	}, "\n")
+
+	img, err := ParseSREC(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("ParseSREC: %v", err)
+	}
+	if len(img.Segments) != 1 {
+		t.Fatalf("Segments = %d, want 1", len(img.Segments))
+	}
+	seg := img.Segments[0]
+	if want := uint32(0x010000); seg.Addr != want {
+		t.Errorf("Addr = 0x%06x, want 0x%06x", seg.Addr, want)
+	}
+	if want := []byte{0xAA, 0xBB}; string(seg.Data) != string(want) {
+		t.Errorf("Data = % x, want % x", seg.Data, want)
+	}
+}
+
+func TestParseSRECBadChecksum(t *testing.T) {
+	// last byte changed from EE to EF, so the checksum no longer matches.
+	src := "S107000001020304EF"
+
+	if _, err := ParseSREC(strings.NewReader(src)); err == nil {
+		t.Fatal("ParseSREC: want error for bad checksum, got nil")
+	}
+}
+
+func TestParseSRECMissingStartCode(t *testing.T) {
+	if _, err := ParseSREC(strings.NewReader("107000001020304EE")); err == nil {
+		t.Fatal("ParseSREC: want error for missing 'S' start code, got nil")
+	}
+}